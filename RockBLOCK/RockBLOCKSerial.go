@@ -9,15 +9,23 @@ package RockBLOCK
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/tarm/serial"
+	"io"
+	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// maxReconnectBackoff caps how long serialReader waits between attempts to
+// reopen a dead transport.
+const maxReconnectBackoff = 30 * time.Second
+
 var initTextMessage = []byte("AT+SBDWT=")
 var initBinaryMessage = []byte("AT+SBDWB=")
 var initSBDSessionExtended = []byte("AT+SBDIX")
@@ -27,9 +35,17 @@ var downloadBinaryMessage = []byte("AT+SBDRB")
 var requestSystemTimeMessage = []byte("AT-MSSTM")
 var clearBuffers = []byte("AT+SBDD0")
 
+// Transport is the byte stream a RockBLOCKSerialConnection speaks AT
+// commands over. github.com/tarm/serial.Port satisfies it, and so does the
+// client end of a `socat PTY,link=...` pair (or a net.Pipe) whose other end
+// is served by the RockBLOCK/mock package's modem emulator, which is what
+// lets SendText, SendBinary and downloadMessage run in tests instead of
+// against a physical 9602.
+type Transport io.ReadWriteCloser
+
 type RockBLOCKSerialConnection struct {
-	SerialConfig      *serial.Config
-	SerialPort        *serial.Port
+	SerialConfig      *SerialConfig
+	Transport         Transport
 	SerialIn          chan []byte
 	SerialOut         chan []byte
 	processedBuffer   [][]byte
@@ -40,7 +56,35 @@ type RockBLOCKSerialConnection struct {
 	mu                *sync.Mutex
 	MTMessages        [][]byte
 	msgHandler        RockBLOCKMTMessageHandler // Callback.
-	persistentMsgChan chan []byte
+	persistentMsgChan chan *pendingMsg
+
+	// Reopen recreates the Transport after the current one fails (unplugged
+	// USB adapter, modem reset, etc). NewRockBLOCKSerial sets this to reopen
+	// the real serial port; NewRockBLOCKSerialWithTransport leaves it nil,
+	// in which case serialReader gives up on the connection for good once
+	// it fails, same as before this was added.
+	Reopen func() (Transport, error)
+
+	connMu sync.RWMutex
+	connUp chan struct{} // Closed while the transport is known to be usable; swapped for a fresh one while it's down.
+
+	nextMsgID  uint32 // Assigns SendBinaryCompressed/SendBinaryFragmented frame IDs.
+	reassembly map[byte]*frameReassembly
+
+	statsMu sync.Mutex
+	stats   Stats
+
+	// MSSTMEpochs overrides the epoch table parseMSSTM picks from; nil uses
+	// DefaultIridiumEpochs. MSSTMTolerance overrides how far the chosen
+	// epoch's wall-clock may land from time.Now() before parseMSSTM logs a
+	// warning and treats the reading as ErrMSSTMSuspect; zero means
+	// DefaultMSSTMTolerance.
+	MSSTMEpochs    []IridiumEpoch
+	MSSTMTolerance time.Duration
+
+	lastEpoch      IridiumEpoch
+	msstmSuspect   bool
+	lastGoodOffset time.Duration // SystemTime - time.Now() as of the last good AT-MSSTM, for extrapolatedTime().
 }
 
 type RockBLOCKCallbackInfo struct {
@@ -55,20 +99,48 @@ const (
 
 type RockBLOCKMTMessageHandler func(RockBLOCKCallbackInfo) error
 
-func NewRockBLOCKSerial() (r *RockBLOCKSerialConnection, err error) {
-	r = new(RockBLOCKSerialConnection)
+// NewRockBLOCKSerial opens a real RockBLOCK modem, configured from the YAML
+// file at configPath (see SerialConfig). An empty configPath falls back to
+// DefaultSerialConfig(), which reproduces the historical
+// /dev/ttyUSB0 @ 19200 baud default.
+func NewRockBLOCKSerial(configPath string) (r *RockBLOCKSerialConnection, err error) {
+	cfg := DefaultSerialConfig()
+	if configPath != "" {
+		cfg, err = LoadSerialConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// Open serial port.
-	cnf := &serial.Config{Name: "/dev/ttyUSB0", Baud: 19200}
-	p, errn := serial.OpenPort(cnf)
+	p, errn := serial.OpenPort(cfg.toTarmConfig())
 	if errn != nil {
 		err = fmt.Errorf("serial port err: %s\n", errn.Error())
 		return
 	}
 
-	// Serial port opened successfully.
-	r.SerialConfig = cnf
-	r.SerialPort = p
+	r, err = NewRockBLOCKSerialWithTransport(cfg, p)
+	if err != nil {
+		return
+	}
+
+	// A real serial port can be reopened after it fails, so let
+	// serialReader recover instead of giving up on the connection for good.
+	r.Reopen = func() (Transport, error) {
+		return serial.OpenPort(cfg.toTarmConfig())
+	}
+
+	return
+}
+
+// NewRockBLOCKSerialWithTransport wires a RockBLOCKSerialConnection up to an
+// arbitrary Transport, bypassing github.com/tarm/serial entirely. This is
+// what the RockBLOCK/mock package's PTY modem plugs into for tests, but it
+// also works for e.g. a net.Conn to a TCP-bridged modem.
+func NewRockBLOCKSerialWithTransport(cfg *SerialConfig, t Transport) (r *RockBLOCKSerialConnection, err error) {
+	r = new(RockBLOCKSerialConnection)
+
+	r.SerialConfig = cfg
+	r.Transport = t
 	// Create mutex.
 	r.mu = &sync.Mutex{}
 
@@ -130,6 +202,7 @@ func (r *RockBLOCKSerialConnection) parseSBDI(msg []byte) error {
 		MTLen:    parms[4],
 		MTQueued: parms[5],
 	}
+	r.recordSBDI(r.SBDI)
 
 	return nil
 }
@@ -146,9 +219,17 @@ func (r *RockBLOCKSerialConnection) parseCSQ(msg []byte) error {
 		return fmt.Errorf("parseCSQ(): Not a valid +CSQ response: %s.", s)
 	}
 	r.SignalQuality = int(i)
+	r.recordCSQ(r.SignalQuality)
 	return nil
 }
 
+// parseMSSTM decodes a -MSSTM: response. The 32-bit count of 90ms ticks it
+// carries is relative to whichever Iridium epoch was in effect when it was
+// read - the network has already rolled the epoch once (see DefaultIridiumEpochs)
+// - and wraps again roughly every 12 years regardless. selectEpoch picks
+// the epoch whose resulting wall-clock lands closest to time.Now(); a
+// 0xFFFFFFFF tick count is the modem's own "suspect" sentinel and skips
+// straight to ErrMSSTMSuspect.
 func (r *RockBLOCKSerialConnection) parseMSSTM(msg []byte) error {
 	s := string(msg)
 	if !strings.HasPrefix(s, "-MSSTM:") {
@@ -156,58 +237,195 @@ func (r *RockBLOCKSerialConnection) parseMSSTM(msg []byte) error {
 	}
 	s = s[7:]
 	v := strings.Trim(s, " ")
-	i, err := strconv.ParseInt(v, 16, 32)
+	i, err := strconv.ParseUint(v, 16, 32)
 	if err != nil {
 		return fmt.Errorf("parseMSSTM(): Not a valid -MSSTM response.")
 	}
+	ticks := uint32(i)
 
-	// Era2: https://www.g1sat.com/download/iridium/2015%20Iridium%20Time%20Epoch%20Change%20ITN0018%20v1.2.pdf.
-	iridiumEpochTime := time.Date(2014, 5, 11, 14, 23, 55, 0, time.UTC)
+	if ticks == 0xFFFFFFFF {
+		r.msstmSuspect = true
+		return ErrMSSTMSuspect
+	}
 
-	// -MMSTM returns the number of 90ms intervals since Iridium Epoch, unless it has rolled over.
-	//FIXME: Rollover detection.
+	t, epoch, err := r.selectEpoch(ticks)
+	if err != nil {
+		return err
+	}
 
-	r.SystemTime = iridiumEpochTime.Add(90 * time.Millisecond * time.Duration(i))
+	r.SystemTime = t
+	r.lastEpoch = epoch
+	r.msstmSuspect = false
+	r.lastGoodOffset = t.Sub(time.Now())
+	r.recordMSSTMDrift(r.lastGoodOffset)
 	return nil
 }
 
+// serialReader is a supervisor loop: it scans lines off r.Transport until
+// the transport errors out or hits EOF (unplugged USB adapter, modem reset,
+// kernel reclaiming the tty), at which point it marks the connection down,
+// and - if r.Reopen is set - keeps retrying r.Reopen with an exponential
+// backoff (capped at maxReconnectBackoff) until it gets a new Transport back,
+// then resumes scanning while the AT/AT&K0 init handshake re-runs in its own
+// goroutine; the connection is marked up again once that handshake succeeds.
 func (r *RockBLOCKSerialConnection) serialReader() {
-	scanner := bufio.NewScanner(r.SerialPort)
-	scanner.Split(RockBLOCKScanSplit)
-	for scanner.Scan() {
-		m := scanner.Bytes()
-		m = bytes.Trim(m, "\r\n")
-		if len(m) > 0 {
-			// Automatic parsing.
-			//TODO Parse all relevant information automatically.
-			if StringPrefix(m, []byte("+SBDI")) {
-				r.parseSBDI(m)
-			}
-			if StringPrefix(m, []byte("+CSQ:")) {
-				r.parseCSQ(m)
-			}
-			if StringPrefix(m, []byte("-MSSTM:")) {
-				r.parseMSSTM(m)
+	backoff := time.Second
+	for {
+		scanner := bufio.NewScanner(r.Transport)
+		scanner.Split(RockBLOCKScanSplit)
+		for scanner.Scan() {
+			m := scanner.Bytes()
+			m = bytes.Trim(m, "\r\n")
+			if len(m) > 0 {
+				// Automatic parsing.
+				//TODO Parse all relevant information automatically.
+				if StringPrefix(m, []byte("+SBDI")) {
+					r.parseSBDI(m)
+				}
+				if StringPrefix(m, []byte("+CSQ:")) {
+					r.parseCSQ(m)
+				}
+				if StringPrefix(m, []byte("-MSSTM:")) {
+					r.parseMSSTM(m)
+				}
+
+				r.SerialIn <- bytes.Trim(m, "\r")
 			}
+		}
+
+		fmt.Printf("serial read error: %v\n", scanner.Err())
+		r.markDown()
 
-			r.SerialIn <- bytes.Trim(m, "\r")
+		if r.Reopen == nil {
+			return
 		}
+
+		for {
+			time.Sleep(backoff)
+			t, err := r.Reopen()
+			if err != nil {
+				fmt.Printf("reconnect error: %s\n", err.Error())
+				if backoff *= 2; backoff > maxReconnectBackoff {
+					backoff = maxReconnectBackoff
+				}
+				continue
+			}
+			r.Transport = t
+			backoff = time.Second
+			break
+		}
+
+		// Re-run the init handshake in its own goroutine: it needs
+		// serialReader's scan loop (started by the next trip around this
+		// outer loop) to already be feeding r.SerialIn to see the replies.
+		go r.reinit()
+	}
+}
+
+func (r *RockBLOCKSerialConnection) reinit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.serialWriteRaw([]byte("AT\r")); err != nil {
+		fmt.Printf("reinit() error: %s\n", err.Error())
+		return
+	}
+	if err := r.serialWaitEqual("OK"); err != nil {
+		fmt.Printf("reinit() error: %s\n", err.Error())
+		return
+	}
+
+	if err := r.serialWriteRaw([]byte("AT&K0\r")); err != nil {
+		fmt.Printf("reinit() error: %s\n", err.Error())
+		return
+	}
+	if err := r.serialWaitEqual("OK"); err != nil {
+		fmt.Printf("reinit() error: %s\n", err.Error())
+		return
+	}
+
+	r.markUp()
+}
+
+// markDown flags the connection as unusable; writers calling serialWrite
+// block on r.connUp until markUp is called after a successful reconnect.
+func (r *RockBLOCKSerialConnection) markDown() {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	select {
+	case <-r.connUp:
+		r.connUp = make(chan struct{})
+	default:
+	}
+}
+
+func (r *RockBLOCKSerialConnection) markUp() {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	select {
+	case <-r.connUp:
+	default:
+		close(r.connUp)
+	}
+}
+
+func (r *RockBLOCKSerialConnection) waitUp(ctx context.Context) error {
+	r.connMu.RLock()
+	ch := r.connUp
+	r.connMu.RUnlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 func (r *RockBLOCKSerialConnection) serialWriter() {
 	for {
 		m := <-r.SerialOut
-		_, err := r.SerialPort.Write(m)
+		_, err := r.Transport.Write(m)
 		if err != nil {
 			fmt.Printf("serial write error: %s\n", err.Error())
 		}
 	}
 }
 
-func (r *RockBLOCKSerialConnection) serialWrite(m []byte) {
+// serialWriteRaw queues m for the serial writer without waiting on the
+// connection state. It's only safe to use from code that already knows the
+// transport is live, e.g. the init/reinit handshakes.
+func (r *RockBLOCKSerialConnection) serialWriteRaw(m []byte) error {
 	fmt.Printf("sent: %s\n", string(m))
 	r.SerialOut <- m
+	return nil
+}
+
+// serialWrite blocks until the connection is up (or ctx is done) before
+// queuing m, rather than dropping bytes into a dead channel while the port
+// is being reopened. If the caller passed a bare context (no deadline of its
+// own) and SerialConfig.WriteTimeout is set, that timeout bounds the wait
+// instead of letting it block indefinitely.
+func (r *RockBLOCKSerialConnection) serialWrite(ctx context.Context, m []byte) error {
+	if r.SerialConfig != nil && r.SerialConfig.WriteTimeout > 0 {
+		if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.SerialConfig.WriteTimeout)
+			defer cancel()
+		}
+	}
+
+	if err := r.waitUp(ctx); err != nil {
+		return err
+	}
+
+	fmt.Printf("sent: %s\n", string(m))
+	select {
+	case r.SerialOut <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 type MsgEqualFunc func([]byte, []byte) bool
@@ -261,19 +479,23 @@ func (r *RockBLOCKSerialConnection) Init() error {
 	r.SerialIn = make(chan []byte)
 	r.SerialOut = make(chan []byte)
 
+	// The transport we were handed is assumed live already.
+	r.connUp = make(chan struct{})
+	close(r.connUp)
+
 	// Start the read/write goroutines.
 	go r.serialReader()
 	go r.serialWriter()
 
 	// Send init command.
-	r.serialWrite([]byte("AT\r"))
+	r.serialWriteRaw([]byte("AT\r"))
 	err := r.serialWaitEqual("OK")
 	if err != nil {
 		return fmt.Errorf("init() error: %s", err.Error())
 	}
 
 	// Turn off flow control.
-	r.serialWrite([]byte("AT&K0\r"))
+	r.serialWriteRaw([]byte("AT&K0\r"))
 	err = r.serialWaitEqual("OK")
 	if err != nil {
 		return fmt.Errorf("init() error: %s", err.Error())
@@ -284,25 +506,38 @@ func (r *RockBLOCKSerialConnection) Init() error {
 	return nil
 }
 
-func (r *RockBLOCKSerialConnection) clearBuffer() error {
+func (r *RockBLOCKSerialConnection) clearBuffer(ctx context.Context) error {
 	cmd := append(clearBuffers, byte('\r'))
-	r.serialWrite(cmd)
+	if err := r.serialWrite(ctx, cmd); err != nil {
+		return err
+	}
 	return r.serialWaitEqual("OK")
 }
 
-func (r *RockBLOCKSerialConnection) SendText(msg []byte) error {
+// SendText blocks until the port is up (or ctx is done) before writing, so a
+// transport that's mid-reconnect fails the send instead of silently
+// dropping it.
+func (r *RockBLOCKSerialConnection) SendText(ctx context.Context, msg []byte) error {
+	// Wait before taking r.mu: reinit() needs r.mu to bring the port back up.
+	if err := r.waitUp(ctx); err != nil {
+		return err
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.clearBuffer()
+	r.clearBuffer(ctx)
 	cmd := append(initTextMessage, msg...)
 	cmd = append(cmd, byte('\r'))
-	r.serialWrite(cmd)
+	if err := r.serialWrite(ctx, cmd); err != nil {
+		return err
+	}
 	err := r.serialWaitEqual("OK")
 	if err != nil {
 		return fmt.Errorf("SendText() error: %s", err.Error())
 	}
-	r.serialWrite(append(initSBDSession, byte('\r')))
+	if err := r.serialWrite(ctx, append(initSBDSession, byte('\r'))); err != nil {
+		return err
+	}
 
 	// Wait for "+SBDI:" message
 	err = r.serialWaitPrefix([]byte("+SBDI:"))
@@ -321,7 +556,7 @@ func (r *RockBLOCKSerialConnection) SendText(msg []byte) error {
 	}
 
 	// Retrieve any message from the buffer, if any.
-	r.downloadMessage()
+	r.downloadMessage(ctx)
 
 	return nil
 
@@ -335,15 +570,23 @@ func (r *RockBLOCKSerialConnection) binaryChecksum(msg []byte) []byte {
 	return []byte{byte((sum & 0xFF00) >> 8), byte(sum & 0xFF)}
 }
 
-//TESTME.
-func (r *RockBLOCKSerialConnection) SendBinary(msg []byte) error {
+// SendBinary blocks until the port is up (or ctx is done) before writing, so
+// a transport that's mid-reconnect fails the send instead of silently
+// dropping it.
+func (r *RockBLOCKSerialConnection) SendBinary(ctx context.Context, msg []byte) error {
+	// Wait before taking r.mu: reinit() needs r.mu to bring the port back up.
+	if err := r.waitUp(ctx); err != nil {
+		return err
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.clearBuffer()
+	r.clearBuffer(ctx)
 	msgLen := len(msg)
 	cmd := append(initBinaryMessage, []byte(fmt.Sprintf("%d\r", msgLen))...)
-	r.serialWrite(cmd)
+	if err := r.serialWrite(ctx, cmd); err != nil {
+		return err
+	}
 
 	// Wait for the "READY" message, then send the whole binary message plus the checksum.
 	err := r.serialWaitEqual("READY")
@@ -352,7 +595,9 @@ func (r *RockBLOCKSerialConnection) SendBinary(msg []byte) error {
 	}
 
 	msgWithChecksum := append(msg, r.binaryChecksum(msg)...)
-	r.serialWrite(msgWithChecksum)
+	if err := r.serialWrite(ctx, msgWithChecksum); err != nil {
+		return err
+	}
 
 	// Wait for "0" (OK) response.
 	err = r.serialWaitEqual("0")
@@ -365,7 +610,9 @@ func (r *RockBLOCKSerialConnection) SendBinary(msg []byte) error {
 		return fmt.Errorf("SendText() error: %s", err.Error())
 	}
 
-	r.serialWrite(append(initSBDSession, byte('\r')))
+	if err := r.serialWrite(ctx, append(initSBDSession, byte('\r'))); err != nil {
+		return err
+	}
 
 	// Wait for "+SBDI:" message
 	err = r.serialWaitPrefix([]byte("+SBDI:"))
@@ -384,15 +631,24 @@ func (r *RockBLOCKSerialConnection) SendBinary(msg []byte) error {
 	}
 
 	// Retrieve any message from the buffer, if any.
-	r.downloadMessage()
+	r.downloadMessage(ctx)
 
 	return nil
 
 }
 
-func (r *RockBLOCKSerialConnection) getSignalQuality() (int, error) {
+// getSignalQuality locks r.mu only for this one AT+CSQ round trip, not for
+// however long the caller ends up polling - that's what lets reinit() get a
+// turn with r.mu between WaitForNetwork's ticks instead of being locked out
+// for its entire duration.
+func (r *RockBLOCKSerialConnection) getSignalQuality(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	msg := append(getSignalQualityMessage, byte('\r'))
-	r.serialWrite(msg)
+	if err := r.serialWrite(ctx, msg); err != nil {
+		return -1, err
+	}
 	if err := r.serialWaitPrefix([]byte("+CSQ:")); err != nil {
 		return -1, err
 	}
@@ -403,22 +659,32 @@ func (r *RockBLOCKSerialConnection) getSignalQuality() (int, error) {
 
 /*
 	WaitForNetwork().
-	 Returns nil if and only if a signal quality indicator greater than 0 is encountered in less than 't'.
+	 Returns nil if and only if a signal quality indicator greater than 0 is encountered in less than 't'; returns ctx.Err() if ctx is done first.
 	 Checks once per 5 seconds.
 */
-func (r *RockBLOCKSerialConnection) WaitForNetwork(t time.Duration) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
+func (r *RockBLOCKSerialConnection) WaitForNetwork(ctx context.Context, t time.Duration) error {
 	finishTicker := time.NewTicker(t)
+	defer finishTicker.Stop()
 	checkTicker := time.NewTicker(5 * time.Second)
+	defer checkTicker.Stop()
 	for {
 		select {
 		case <-finishTicker.C:
 			return errors.New("Timeout.")
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-checkTicker.C:
-			signal, err := r.getSignalQuality()
+			// Bound each check to the tick interval: if the transport is
+			// down, waitUp inside serialWrite would otherwise block for
+			// however long it takes reinit() to recover, and it can never
+			// recover while this call is squatting on r.mu.
+			checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			signal, err := r.getSignalQuality(checkCtx)
+			cancel()
 			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					continue
+				}
 				return err
 			}
 			if signal != 0 {
@@ -426,25 +692,43 @@ func (r *RockBLOCKSerialConnection) WaitForNetwork(t time.Duration) error {
 			}
 		}
 	}
-	return errors.New("Timeout.")
 }
 
-func (r *RockBLOCKSerialConnection) GetTime() (time.Time, error) {
+// GetTime returns the modem's Iridium-epoch system time, blocking until the
+// port is up (or ctx is done) before writing. See GetTimeDetailed for the
+// epoch it was decoded against and ErrMSSTMSuspect handling.
+func (r *RockBLOCKSerialConnection) GetTime(ctx context.Context) (time.Time, error) {
+	t, _, err := r.GetTimeDetailed(ctx)
+	return t, err
+}
+
+// GetTimeDetailed returns the modem's system time along with which
+// IridiumEpoch it was decoded against. If the modem's last AT-MSSTM reading
+// looked like a rollover or a spurious value, it returns a monotonic
+// extrapolation from the last good reading plus ErrMSSTMSuspect instead of a
+// bogus timestamp.
+func (r *RockBLOCKSerialConnection) GetTimeDetailed(ctx context.Context) (time.Time, IridiumEpoch, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	msg := append(requestSystemTimeMessage, byte('\r'))
-	r.serialWrite(msg)
+	if err := r.serialWrite(ctx, msg); err != nil {
+		return time.Now(), IridiumEpoch{}, err // time.Now(): Best effort.
+	}
 	if err := r.serialWaitPrefix([]byte("-MSSTM:")); err != nil {
-		return time.Now(), err // time.Now(): Best effort.
+		return time.Now(), IridiumEpoch{}, err // time.Now(): Best effort.
 	}
 
 	r.serialWaitEqual("OK")
 
-	return r.SystemTime, nil
+	if r.msstmSuspect {
+		return r.extrapolatedTime(), IridiumEpoch{}, ErrMSSTMSuspect
+	}
+
+	return r.SystemTime, r.lastEpoch, nil
 }
 
-func (r *RockBLOCKSerialConnection) downloadMessage() error {
+func (r *RockBLOCKSerialConnection) downloadMessage(ctx context.Context) error {
 	// Check if we have messages waiting.
 	if r.SBDI.MTStatus != 1 {
 		// No messages.
@@ -453,7 +737,9 @@ func (r *RockBLOCKSerialConnection) downloadMessage() error {
 
 	// Initiate the download.
 	msg := append(downloadBinaryMessage, byte('\r'))
-	r.serialWrite(msg)
+	if err := r.serialWrite(ctx, msg); err != nil {
+		return err
+	}
 
 	err := r.serialWaitSuffix([]byte("OK")) // Device sends "OK" after the transfer.
 	if err != nil {
@@ -502,6 +788,13 @@ func (r *RockBLOCKSerialConnection) downloadMessage() error {
 		return fmt.Errorf("downloadMessage(): Bad checksum: msgChecksum=%02x%02x, myChecksum=%02x02x", msgChecksum[0], msgChecksum[1], myChecksum[0], myChecksum[1])
 	}
 
+	// If finalMsg is a SendBinaryCompressed/SendBinaryFragmented frame,
+	// deliverFramed buffers it and calls msgHandler itself only once the
+	// whole message has been reassembled and decompressed.
+	if framed, err := r.deliverFramed(finalMsg); framed {
+		return err
+	}
+
 	if r.msgHandler != nil {
 		conf := RockBLOCKCallbackInfo{
 			Data:  finalMsg,
@@ -516,31 +809,92 @@ func (r *RockBLOCKSerialConnection) SetMessageHandler(f RockBLOCKMTMessageHandle
 	r.msgHandler = f
 }
 
-// Constantly retries each message until it is sent.
+// sendBackoffStart/sendBackoffCap bound the jittered exponential backoff
+// persistentMessageSender falls back to when a send fails for a reason
+// other than "no network" (gateway error, bad checksum, etc), so a modem in
+// a dead zone doesn't get hammered with back-to-back AT+SBDIX attempts.
+const (
+	sendBackoffStart = 10 * time.Second
+	sendBackoffCap   = 10 * time.Minute
+)
+
+// jitteredBackoff returns d +/- 20%, so concurrent connections with the same
+// backoff schedule don't retry in lockstep.
+func jitteredBackoff(d time.Duration) time.Duration {
+	spread := float64(d) * 0.2
+	return d + time.Duration(spread*(rand.Float64()*2-1))
+}
+
+// Retries each message until it is sent or it runs out of attempts/context,
+// then resolves its SendFuture instead of going through msgHandler - sent
+// confirmations and MT deliveries no longer share one callback. On failure
+// it treats "no signal" as a distinct, retryable condition from a MO/gateway
+// error: the former waits on WaitForNetwork instead of spinning, the latter
+// backs off exponentially.
 func (r *RockBLOCKSerialConnection) persistentMessageSender() {
-	r.persistentMsgChan = make(chan []byte, 1024)
+	r.persistentMsgChan = make(chan *pendingMsg, 1024)
 	for {
-		m := <-r.persistentMsgChan
+		p := <-r.persistentMsgChan
+		backoff := sendBackoffStart
+		attempts := 0
+
 		for {
-			err := r.SendBinary(m)
-			// Try until successful.
-			if err != nil {
-				fmt.Printf("send error: %s\n", err.Error())
-			} else {
-				if r.msgHandler != nil {
-					conf := RockBLOCKCallbackInfo{
-						Data:  m,
-						State: CALLBACK_CONFIRM_SENT,
-					}
-					r.msgHandler(conf) //FIXME: Set up a separate channel for "sent" notifications.
-				}
+			if err := p.ctx.Err(); err != nil {
+				p.resolve(SendResult{}, err)
+				break
+			}
+			if p.maxAttempts > 0 && attempts >= p.maxAttempts {
+				p.resolve(SendResult{}, fmt.Errorf("persistentMessageSender(): gave up after %d attempts", attempts))
+				break
+			}
+
+			attempts++
+			r.recordAttempt()
+
+			err := r.SendBinary(p.ctx, p.payload)
+			if err == nil {
+				r.recordBytesSent(len(p.payload))
+				p.resolve(SendResult{MOMSN: r.SBDI.MOMSN, SystemTime: r.SystemTime}, nil)
 				fmt.Printf("sent\n")
 				break
 			}
+
+			fmt.Printf("send error: %s\n", err.Error())
+
+			if r.SignalQuality == 0 {
+				// No network: wait for it instead of burning airtime on
+				// another blind AT+SBDIX. Honor p.ctx so a caller that gives
+				// up on this message doesn't leave the queue's one sender
+				// goroutine parked on WaitForNetwork past its deadline.
+				if werr := r.WaitForNetwork(p.ctx, jitteredBackoff(backoff)*2); werr != nil {
+					fmt.Printf("WaitForNetwork error: %s\n", werr.Error())
+				}
+			} else {
+				select {
+				case <-time.After(jitteredBackoff(backoff)):
+				case <-p.ctx.Done():
+				}
+			}
+
+			if backoff *= 2; backoff > sendBackoffCap {
+				backoff = sendBackoffCap
+			}
 		}
 	}
 }
 
-func (r *RockBLOCKSerialConnection) SendBinaryPersistent(m []byte) {
-	r.persistentMsgChan <- m
+// SendBinaryPersistent enqueues m to be retried (honoring ctx's deadline and
+// maxAttempts - 0 meaning unlimited) until the gateway acknowledges it, and
+// returns a SendFuture the caller can Wait() on to find out which MOMSN the
+// gateway assigned it.
+func (r *RockBLOCKSerialConnection) SendBinaryPersistent(ctx context.Context, m []byte, maxAttempts int) *SendFuture {
+	p := &pendingMsg{
+		id:          uuid.New(),
+		payload:     m,
+		done:        make(chan sendOutcome, 1),
+		ctx:         ctx,
+		maxAttempts: maxAttempts,
+	}
+	r.persistentMsgChan <- p
+	return &SendFuture{p: p}
 }
@@ -0,0 +1,91 @@
+package RockBLOCK
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doyke/weatherserver/RockBLOCK/mock"
+)
+
+// newTestConnection wires a RockBLOCKSerialConnection up to a mock.Modem over
+// an in-memory net.Pipe, so SendText/SendBinary/downloadMessage can be
+// exercised without a physical 9602 or a real PTY.
+func newTestConnection(t *testing.T) (*RockBLOCKSerialConnection, *mock.Modem) {
+	t.Helper()
+
+	clientSide, modemSide := net.Pipe()
+	m := mock.NewModem(modemSide)
+	t.Cleanup(func() {
+		m.Close()
+		clientSide.Close()
+	})
+
+	r, err := NewRockBLOCKSerialWithTransport(DefaultSerialConfig(), clientSide)
+	if err != nil {
+		t.Fatalf("NewRockBLOCKSerialWithTransport(): %s", err)
+	}
+	return r, m
+}
+
+func TestSendText(t *testing.T) {
+	r, m := newTestConnection(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.SendText(ctx, []byte("hello")); err != nil {
+		t.Fatalf("SendText(): %s", err)
+	}
+
+	if got := string(m.LastMO()); got != "hello" {
+		t.Errorf("modem got MO %q, want %q", got, "hello")
+	}
+}
+
+func TestSendBinary(t *testing.T) {
+	r, m := newTestConnection(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg := []byte{0x01, 0x02, 0x03, 0xFF}
+	if err := r.SendBinary(ctx, msg); err != nil {
+		t.Fatalf("SendBinary(): %s", err)
+	}
+
+	if got := m.LastMO(); string(got) != string(msg) {
+		t.Errorf("modem got MO %x, want %x", got, msg)
+	}
+}
+
+func TestDownloadMessage(t *testing.T) {
+	r, m := newTestConnection(t)
+
+	mtMsg := []byte("mt payload")
+	m.QueueMTMessage(mtMsg)
+	r.SBDI.MTStatus = 1 // As if a preceding AT+SBDI session just reported mail waiting.
+
+	received := make(chan []byte, 1)
+	r.SetMessageHandler(func(info RockBLOCKCallbackInfo) error {
+		received <- info.Data
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.downloadMessage(ctx); err != nil {
+		t.Fatalf("downloadMessage(): %s", err)
+	}
+
+	select {
+	case got := <-received:
+		if string(got) != string(mtMsg) {
+			t.Errorf("downloaded MT message = %q, want %q", got, mtMsg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for msgHandler callback")
+	}
+}
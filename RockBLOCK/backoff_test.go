@@ -0,0 +1,19 @@
+package RockBLOCK
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitteredBackoffStaysWithinSpread(t *testing.T) {
+	d := 10 * time.Second
+	min := d - d/5
+	max := d + d/5
+
+	for i := 0; i < 100; i++ {
+		got := jitteredBackoff(d)
+		if got < min || got > max {
+			t.Fatalf("jitteredBackoff(%s) = %s, want within [%s, %s]", d, got, min, max)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package RockBLOCK
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+	"gopkg.in/yaml.v2"
+)
+
+// SerialConfig describes how to open and frame the physical (or emulated)
+// serial link to a RockBLOCK modem. It is intentionally decoupled from
+// github.com/tarm/serial.Config so callers can load it from YAML without
+// depending on that package's types.
+type SerialConfig struct {
+	Address  string        `yaml:"address"`
+	Baud     int           `yaml:"baud"`
+	DataBits int           `yaml:"databits"`
+	StopBits int           `yaml:"stopbits"`
+	Parity   string        `yaml:"parity"` // "N", "E" or "O".
+	// ReadTimeout is passed straight through to the underlying
+	// github.com/tarm/serial.Config.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// WriteTimeout bounds how long serialWrite waits for the connection to
+	// come up and the write to be queued, when the caller's own context has
+	// no deadline. Zero means wait indefinitely (ctx permitting).
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+}
+
+// DefaultSerialConfig returns the settings NewRockBLOCKSerial used to
+// hard-code: a 9602 on /dev/ttyUSB0 at 19200 baud, 8N1.
+func DefaultSerialConfig() *SerialConfig {
+	return &SerialConfig{
+		Address:  "/dev/ttyUSB0",
+		Baud:     19200,
+		DataBits: 8,
+		StopBits: 1,
+		Parity:   "N",
+	}
+}
+
+// LoadSerialConfig reads a SerialConfig from a YAML file at path, starting
+// from DefaultSerialConfig() so a config only needs to override the fields
+// it cares about.
+func LoadSerialConfig(path string) (*SerialConfig, error) {
+	cfg := DefaultSerialConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadSerialConfig(): %s", err.Error())
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("LoadSerialConfig(): %s", err.Error())
+	}
+
+	return cfg, nil
+}
+
+// toTarmConfig converts to the github.com/tarm/serial config used to open
+// the real port.
+func (c *SerialConfig) toTarmConfig() *serial.Config {
+	cfg := &serial.Config{
+		Name:        c.Address,
+		Baud:        c.Baud,
+		ReadTimeout: c.ReadTimeout,
+	}
+
+	if c.DataBits > 0 {
+		cfg.Size = byte(c.DataBits)
+	}
+
+	switch c.StopBits {
+	case 2:
+		cfg.StopBits = serial.Stop2
+	default:
+		cfg.StopBits = serial.Stop1
+	}
+
+	switch strings.ToUpper(c.Parity) {
+	case "E":
+		cfg.Parity = serial.ParityEven
+	case "O":
+		cfg.Parity = serial.ParityOdd
+	default:
+		cfg.Parity = serial.ParityNone
+	}
+
+	return cfg
+}
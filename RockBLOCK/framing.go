@@ -0,0 +1,216 @@
+package RockBLOCK
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+
+	"github.com/pierrec/lz4"
+)
+
+// Iridium SBD caps a single MO message at 340 bytes and MT at 270, which
+// truncates any telemetry payload bigger than that. SendBinaryCompressed and
+// SendBinaryFragmented layer LZ4 compression and fragmentation on top of
+// SendBinary/downloadMessage so callers can hand over a []byte bigger than
+// one message (up to frameMaxTotalLen bytes after compression) instead of
+// bit-exact-packing it themselves; SendBinary is still there for users who
+// need that control.
+
+const (
+	frameMagic = 0xB1
+
+	frameFlagCompressed = 1 << 0
+	frameFlagFragmented = 1 << 1
+)
+
+// frameHeaderLen is magic(1) + flags(1) + msgID(1) + totalLen(2) +
+// fragIndex(1) + fragCount(1).
+const frameHeaderLen = 7
+const frameCRCLen = 4
+
+// frameMaxFragmentPayload leaves room, under a single MO message's 340-byte
+// cap, for our header/CRC plus the modem's own 2-byte checksum trailer that
+// SendBinary adds.
+const frameMaxFragmentPayload = 340 - frameHeaderLen - frameCRCLen - 2
+
+// frameMaxTotalLen is the largest payload TotalLen can represent - it's
+// encoded as a 2-byte field, independent of the 255-fragment cap (255 *
+// frameMaxFragmentPayload alone would overflow it).
+const frameMaxTotalLen = 1<<16 - 1
+
+type frameHeader struct {
+	Flags     byte
+	MsgID     byte
+	TotalLen  int
+	FragIndex int
+	FragCount int
+}
+
+func encodeFrame(h frameHeader, data []byte) []byte {
+	frame := make([]byte, 0, frameHeaderLen+len(data)+frameCRCLen)
+	frame = append(frame, frameMagic, h.Flags, h.MsgID,
+		byte(h.TotalLen>>8), byte(h.TotalLen),
+		byte(h.FragIndex), byte(h.FragCount))
+	frame = append(frame, data...)
+
+	crc := crc32.ChecksumIEEE(data)
+	return append(frame, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func decodeFrame(raw []byte) (frameHeader, []byte, error) {
+	if len(raw) < frameHeaderLen+frameCRCLen || raw[0] != frameMagic {
+		return frameHeader{}, nil, fmt.Errorf("decodeFrame(): not a framed message")
+	}
+
+	h := frameHeader{
+		Flags:     raw[1],
+		MsgID:     raw[2],
+		TotalLen:  int(raw[3])<<8 | int(raw[4]),
+		FragIndex: int(raw[5]),
+		FragCount: int(raw[6]),
+	}
+
+	data := raw[frameHeaderLen : len(raw)-frameCRCLen]
+	wantCRC := raw[len(raw)-frameCRCLen:]
+
+	crc := crc32.ChecksumIEEE(data)
+	if wantCRC[0] != byte(crc>>24) || wantCRC[1] != byte(crc>>16) || wantCRC[2] != byte(crc>>8) || wantCRC[3] != byte(crc) {
+		return frameHeader{}, nil, fmt.Errorf("decodeFrame(): CRC mismatch")
+	}
+
+	return h, data, nil
+}
+
+// frameReassembly accumulates the fragments of one framed message, keyed by
+// its MsgID, as they arrive across successive downloadMessage calls.
+type frameReassembly struct {
+	header frameHeader
+	got    []bool
+	buf    []byte
+}
+
+// SendBinaryFragmented LZ4-compresses msg only if it helps, splits it into
+// ≤frameMaxFragmentPayload-byte fragments framed with encodeFrame, and sends
+// each over SendBinary in order.
+func (r *RockBLOCKSerialConnection) SendBinaryFragmented(ctx context.Context, msg []byte) error {
+	return r.sendFramed(ctx, msg, false)
+}
+
+// SendBinaryCompressed is SendBinaryFragmented except that it keeps the LZ4
+// output even when it is no smaller than msg, for callers who know their
+// payload compresses well. A payload LZ4 cannot fit into len(msg) bytes is
+// still sent uncompressed.
+func (r *RockBLOCKSerialConnection) SendBinaryCompressed(ctx context.Context, msg []byte) error {
+	return r.sendFramed(ctx, msg, true)
+}
+
+func (r *RockBLOCKSerialConnection) sendFramed(ctx context.Context, msg []byte, forceCompress bool) error {
+	payload := msg
+	var flags byte
+
+	compressed := make([]byte, len(msg))
+	n, err := lz4.CompressBlock(msg, compressed, nil)
+	if err == nil && n > 0 && (forceCompress || n < len(msg)) {
+		payload = compressed[:n]
+		flags |= frameFlagCompressed
+	}
+
+	if len(payload) > frameMaxTotalLen {
+		return fmt.Errorf("sendFramed(): message too large: %d bytes, %d max (TotalLen is a 2-byte field)", len(payload), frameMaxTotalLen)
+	}
+
+	fragCount := (len(payload) + frameMaxFragmentPayload - 1) / frameMaxFragmentPayload
+	if fragCount == 0 {
+		fragCount = 1
+	}
+	if fragCount > 255 {
+		return fmt.Errorf("sendFramed(): message too large: %d fragments needed, 255 max", fragCount)
+	}
+	if fragCount > 1 {
+		flags |= frameFlagFragmented
+	}
+
+	msgID := byte(atomic.AddUint32(&r.nextMsgID, 1))
+
+	for i := 0; i < fragCount; i++ {
+		start := i * frameMaxFragmentPayload
+		end := start + frameMaxFragmentPayload
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		h := frameHeader{
+			Flags:     flags,
+			MsgID:     msgID,
+			TotalLen:  len(payload),
+			FragIndex: i,
+			FragCount: fragCount,
+		}
+
+		if err := r.SendBinary(ctx, encodeFrame(h, payload[start:end])); err != nil {
+			return fmt.Errorf("sendFramed(): fragment %d/%d: %s", i+1, fragCount, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// deliverFramed is downloadMessage's hook for a just-downloaded MT payload.
+// It returns true if raw was one of our frames (consumed here, and handed
+// to msgHandler only once the whole message has been reassembled and
+// decompressed); false means raw wasn't ours and downloadMessage should
+// dispatch it as-is.
+func (r *RockBLOCKSerialConnection) deliverFramed(raw []byte) (bool, error) {
+	h, data, err := decodeFrame(raw)
+	if err != nil {
+		return false, nil
+	}
+
+	if r.reassembly == nil {
+		r.reassembly = make(map[byte]*frameReassembly)
+	}
+
+	asm := r.reassembly[h.MsgID]
+	if asm == nil || asm.header.FragCount != h.FragCount || asm.header.TotalLen != h.TotalLen {
+		asm = &frameReassembly{
+			header: h,
+			got:    make([]bool, h.FragCount),
+			buf:    make([]byte, h.TotalLen),
+		}
+		r.reassembly[h.MsgID] = asm
+	}
+
+	if h.FragIndex >= h.FragCount {
+		delete(r.reassembly, h.MsgID)
+		return true, fmt.Errorf("deliverFramed(): fragment index %d out of range [0,%d)", h.FragIndex, h.FragCount)
+	}
+
+	start := h.FragIndex * frameMaxFragmentPayload
+	copy(asm.buf[start:], data)
+	asm.got[h.FragIndex] = true
+
+	for _, g := range asm.got {
+		if !g {
+			return true, nil // Still waiting on more fragments.
+		}
+	}
+
+	delete(r.reassembly, h.MsgID)
+
+	final := asm.buf
+	if h.Flags&frameFlagCompressed != 0 {
+		out := make([]byte, 64*1024)
+		n, err := lz4.UncompressBlock(final, out)
+		if err != nil {
+			return true, fmt.Errorf("deliverFramed(): decompress: %s", err.Error())
+		}
+		final = out[:n]
+	}
+
+	if r.msgHandler != nil {
+		r.msgHandler(RockBLOCKCallbackInfo{Data: final, State: CALLBACK_RECV})
+	}
+
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package RockBLOCK
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SendResult is what a SendFuture resolves to once the gateway has
+// acknowledged a persistently-sent message.
+type SendResult struct {
+	MOMSN      int
+	SystemTime time.Time
+}
+
+// pendingMsg is one message enqueued via SendBinaryPersistent, in flight
+// toward persistentMessageSender. id exists so future revisions can
+// correlate log lines and gateway state to a specific enqueue, since MOMSN
+// isn't known until the gateway accepts the send.
+type pendingMsg struct {
+	id      uuid.UUID
+	payload []byte
+	done    chan sendOutcome
+
+	ctx         context.Context
+	maxAttempts int // 0 = retry indefinitely.
+}
+
+type sendOutcome struct {
+	result SendResult
+	err    error
+}
+
+func (p *pendingMsg) resolve(result SendResult, err error) {
+	p.done <- sendOutcome{result: result, err: err}
+	close(p.done)
+}
+
+// SendFuture lets a SendBinaryPersistent caller find out which MOMSN the
+// gateway assigned its message, without blocking the sender goroutine on
+// anyone actually listening.
+type SendFuture struct {
+	p *pendingMsg
+}
+
+// Wait blocks until the message has been acknowledged by the gateway, the
+// sender has given up on it (its own ctx done or maxAttempts used up, which
+// resolves it with an error), or ctx is done, whichever comes first.
+func (f *SendFuture) Wait(ctx context.Context) (SendResult, error) {
+	select {
+	case o := <-f.p.done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return SendResult{}, ctx.Err()
+	}
+}
@@ -0,0 +1,37 @@
+package RockBLOCK
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSendFutureWaitResolves(t *testing.T) {
+	p := &pendingMsg{id: uuid.New(), done: make(chan sendOutcome, 1)}
+	f := &SendFuture{p: p}
+
+	want := SendResult{MOMSN: 5, SystemTime: time.Now()}
+	p.resolve(want, nil)
+
+	got, err := f.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("Wait(): %s", err)
+	}
+	if got.MOMSN != want.MOMSN {
+		t.Errorf("Wait() MOMSN = %d, want %d", got.MOMSN, want.MOMSN)
+	}
+}
+
+func TestSendFutureWaitCtxDone(t *testing.T) {
+	p := &pendingMsg{id: uuid.New(), done: make(chan sendOutcome, 1)}
+	f := &SendFuture{p: p}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := f.Wait(ctx); err == nil {
+		t.Error("Wait() with an already-canceled ctx returned no error")
+	}
+}
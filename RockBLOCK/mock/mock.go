@@ -0,0 +1,206 @@
+// Package mock implements enough of the RockBLOCK 9602 AT command set (AT,
+// AT&K0, AT+SBDWT, AT+SBDWB, AT+SBDIX, AT+CSQ, AT-MSSTM, AT+SBDRB, AT+SBDD0)
+// to drive RockBLOCK.RockBLOCKSerialConnection end-to-end without a physical
+// modem. Point it at one end of a `socat PTY,link=<a> PTY,link=<b>` pair and
+// open the RockBLOCK client on the other end via
+// RockBLOCK.NewRockBLOCKSerialWithTransport.
+package mock
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Modem emulates a RockBLOCK 9602 on a PTY.
+type Modem struct {
+	port io.ReadWriteCloser
+	mu   sync.Mutex
+
+	// MTQueue holds mobile-terminated messages waiting to be delivered on
+	// the next AT+SBDRB, in the order they'll be popped.
+	MTQueue [][]byte
+	// Signal is the value reported for AT+CSQ.
+	Signal int
+	// MSSTM is the 90ms-tick value reported for AT-MSSTM.
+	MSSTM uint32
+
+	momsn  int
+	lastMO []byte // Last mobile-originated payload accepted, for test assertions.
+}
+
+// Open attaches a Modem to the PTY at path and starts serving AT commands on
+// it in the background until Close is called.
+func Open(path string) (*Modem, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mock.Open(): %s", err.Error())
+	}
+
+	return NewModem(f), nil
+}
+
+// NewModem attaches a Modem to an arbitrary full-duplex rw and starts
+// serving AT commands on it in the background until Close is called. Open is
+// the PTY-backed entry point production code uses; tests that don't need a
+// real PTY (e.g. over a net.Pipe) can call this directly.
+func NewModem(rw io.ReadWriteCloser) *Modem {
+	m := &Modem{port: rw, Signal: 5}
+	go m.serve()
+	return m
+}
+
+// Close stops serving and releases the PTY.
+func (m *Modem) Close() error {
+	return m.port.Close()
+}
+
+// QueueMTMessage enqueues msg to be handed to the client on its next
+// AT+SBDRB, as if the Iridium gateway had a mobile-terminated message
+// waiting.
+func (m *Modem) QueueMTMessage(msg []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.MTQueue = append(m.MTQueue, msg)
+}
+
+// LastMO returns the last mobile-originated payload the modem accepted via
+// AT+SBDWT or AT+SBDWB.
+func (m *Modem) LastMO() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastMO
+}
+
+func (m *Modem) write(s string) {
+	io.WriteString(m.port, s)
+}
+
+// readCommand reads one '\r'-terminated AT command line, mirroring
+// RockBLOCK.RockBLOCKScanSplit. It's built on a bufio.Reader rather than a
+// bufio.Scanner so handleSBDWB can fall back to raw, fixed-length reads off
+// the same buffered stream for binary payloads, which won't reliably contain
+// a '\r' to delimit on.
+func readCommand(r *bufio.Reader) (string, error) {
+	line, err := r.ReadBytes('\r')
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
+
+func (m *Modem) serve() {
+	reader := bufio.NewReader(m.port)
+	for {
+		cmd, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if len(cmd) == 0 {
+			continue
+		}
+		m.handle(cmd, reader)
+	}
+}
+
+func (m *Modem) handle(cmd string, reader *bufio.Reader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Echo the command before responding, same as a real 9602 with its
+	// default ATE1 echo on. downloadMessage relies on seeing this echo to
+	// find where the AT+SBDRB response starts in the client's processed
+	// line buffer.
+	m.write(cmd + "\r")
+
+	switch {
+	case cmd == "AT":
+		m.write("OK\r")
+	case cmd == "AT&K0":
+		m.write("OK\r")
+	case cmd == "AT+SBDD0":
+		m.MTQueue = nil
+		m.write("0\r\nOK\r")
+	case strings.HasPrefix(cmd, "AT+SBDWT="):
+		m.lastMO = []byte(strings.TrimPrefix(cmd, "AT+SBDWT="))
+		m.write("OK\r")
+	case strings.HasPrefix(cmd, "AT+SBDWB="):
+		m.handleSBDWB(strings.TrimPrefix(cmd, "AT+SBDWB="), reader)
+	case cmd == "AT+SBDIX":
+		m.handleSBDI(true)
+	case cmd == "AT+SBDI":
+		m.handleSBDI(false)
+	case cmd == "AT+CSQ":
+		m.write(fmt.Sprintf("+CSQ:%d\r\nOK\r", m.Signal))
+	case cmd == "AT+SBDRB":
+		m.handleSBDRB()
+	case cmd == "AT-MSSTM":
+		m.write(fmt.Sprintf("-MSSTM:%08X\r\nOK\r", m.MSSTM))
+	default:
+		m.write("ERROR\r")
+	}
+}
+
+// handleSBDWB reads the exact msgLen+2 (checksum) raw bytes announced by
+// AT+SBDWB=<msgLen> off reader directly, rather than scanning for a '\r' -
+// the binary payload has no reason to contain one.
+func (m *Modem) handleSBDWB(msgLen string, reader *bufio.Reader) {
+	m.write("READY\r")
+
+	n, err := strconv.Atoi(msgLen)
+	if err != nil || n <= 0 {
+		m.write("2\r\nOK\r") // Incomplete write.
+		return
+	}
+
+	payload := make([]byte, n+2) // +2 for the trailing checksum.
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return
+	}
+
+	m.lastMO = payload[:n]
+	m.write("0\r\nOK\r")
+}
+
+// handleSBDI answers AT+SBDI (extended == false) or AT+SBDIX
+// (extended == true). Both report the same fields; only the prefix differs.
+func (m *Modem) handleSBDI(extended bool) {
+	m.momsn++
+
+	mtStatus, mtLen, mtQueued := 0, 0, len(m.MTQueue)
+	if mtQueued > 0 {
+		mtStatus = 1
+		mtLen = len(m.MTQueue[0])
+	}
+
+	prefix := "+SBDI:"
+	if extended {
+		prefix = "+SBDIX:"
+	}
+	m.write(fmt.Sprintf("%s 1, %d, %d, %d, %d, %d\r\nOK\r", prefix, m.momsn, mtStatus, m.momsn, mtLen, mtQueued))
+}
+
+func (m *Modem) handleSBDRB() {
+	if len(m.MTQueue) == 0 {
+		m.write("OK\r")
+		return
+	}
+
+	msg := m.MTQueue[0]
+	m.MTQueue = m.MTQueue[1:]
+
+	var sum int32
+	for _, b := range msg {
+		sum += int32(b)
+	}
+	checksum := []byte{byte((sum & 0xFF00) >> 8), byte(sum & 0xFF)}
+
+	hdr := []byte{byte(len(msg) >> 8), byte(len(msg) & 0xFF)}
+	m.port.Write(append(append(hdr, msg...), checksum...))
+	m.write("\r\nOK\r")
+}
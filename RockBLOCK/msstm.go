@@ -0,0 +1,108 @@
+package RockBLOCK
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMSSTMSuspect is returned (alongside a monotonic extrapolation from the
+// last good reading, not a zero value) when the modem's last AT-MSSTM
+// reading can't be trusted - either it was the 0xFFFFFFFF sentinel, or no
+// epoch in the table landed within tolerance of time.Now().
+var ErrMSSTMSuspect = errors.New("RockBLOCK: -MSSTM reading suspect (rollover or spurious value); time is extrapolated")
+
+// IridiumEpoch is one epoch AT-MSSTM's 90ms tick counter may be counting
+// from.
+type IridiumEpoch struct {
+	Name  string
+	Epoch time.Time
+}
+
+// DefaultIridiumEpochs are the epochs Iridium has used to date. Era 2
+// superseded Era 1 on 2014-05-11; see
+// https://www.g1sat.com/download/iridium/2015%20Iridium%20Time%20Epoch%20Change%20ITN0018%20v1.2.pdf.
+// Add future eras here as Iridium rolls them.
+var DefaultIridiumEpochs = []IridiumEpoch{
+	{Name: "era1", Epoch: time.Date(1996, 6, 1, 0, 0, 11, 0, time.UTC)},
+	{Name: "era2", Epoch: time.Date(2014, 5, 11, 14, 23, 55, 0, time.UTC)},
+}
+
+// DefaultMSSTMTolerance is how far a chosen epoch's resulting wall-clock may
+// land from time.Now() before parseMSSTM treats the reading as suspect
+// rather than trusting it.
+const DefaultMSSTMTolerance = 365 * 24 * time.Hour
+
+// msstmRolloverPeriod is how often AT-MSSTM's 32-bit, 90ms-tick counter
+// wraps within a single epoch: 2^32 * 90ms, about 12.25 years.
+const msstmRolloverPeriod = 90 * time.Millisecond * (1 << 32)
+
+// msstmNow stands in for time.Now so tests can exercise selectEpoch at
+// points in time (like a rollover boundary) that haven't happened yet.
+var msstmNow = time.Now
+
+// selectEpoch picks, among r.MSSTMEpochs (or DefaultIridiumEpochs) and their
+// successive rollovers, whichever puts ticks*90ms closest to time.Now(),
+// since that's the one the modem is actually counting from. Checking only
+// each epoch's first 2^32-tick window isn't enough - Era 2 alone will wrap
+// internally several times over the life of a deployed modem - so for each
+// epoch we also try the rollover straddling time.Now() and its immediate
+// neighbors.
+func (r *RockBLOCKSerialConnection) selectEpoch(ticks uint32) (time.Time, IridiumEpoch, error) {
+	epochs := r.MSSTMEpochs
+	if len(epochs) == 0 {
+		epochs = DefaultIridiumEpochs
+	}
+
+	tolerance := r.MSSTMTolerance
+	if tolerance <= 0 {
+		tolerance = DefaultMSSTMTolerance
+	}
+
+	now := msstmNow()
+	var best IridiumEpoch
+	var bestTime time.Time
+	var bestDiff time.Duration
+	found := false
+
+	for _, e := range epochs {
+		elapsed := now.Sub(e.Epoch)
+		rollover := int64(0)
+		if elapsed > 0 {
+			rollover = int64(elapsed / msstmRolloverPeriod)
+		}
+
+		for _, k := range []int64{rollover - 1, rollover, rollover + 1} {
+			if k < 0 {
+				continue
+			}
+			candidate := e.Epoch.Add(msstmRolloverPeriod*time.Duration(k) + 90*time.Millisecond*time.Duration(ticks))
+			diff := candidate.Sub(now)
+			if diff < 0 {
+				diff = -diff
+			}
+			if !found || diff < bestDiff {
+				found, best, bestTime, bestDiff = true, e, candidate, diff
+			}
+		}
+	}
+
+	if !found {
+		return now, IridiumEpoch{}, errors.New("selectEpoch(): no epoch table configured")
+	}
+
+	if bestDiff > tolerance {
+		fmt.Printf("selectEpoch(): closest epoch %q still puts system time %s outside the %s tolerance of now; treating reading as suspect\n", best.Name, bestTime, tolerance)
+		r.msstmSuspect = true
+		return r.extrapolatedTime(), IridiumEpoch{}, ErrMSSTMSuspect
+	}
+
+	return bestTime, best, nil
+}
+
+// extrapolatedTime extends the last known-good MSSTM offset forward using
+// the local clock, for callers that need a best-effort time while the
+// modem's own reading is ErrMSSTMSuspect.
+func (r *RockBLOCKSerialConnection) extrapolatedTime() time.Time {
+	return time.Now().Add(r.lastGoodOffset)
+}
@@ -0,0 +1,79 @@
+package RockBLOCK
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// withMSSTMNow points msstmNow at a fixed instant for the duration of fn, so
+// selectEpoch can be tested against rollover boundaries that haven't
+// happened yet on the real clock.
+func withMSSTMNow(t *testing.T, now time.Time, fn func()) {
+	t.Helper()
+	orig := msstmNow
+	msstmNow = func() time.Time { return now }
+	defer func() { msstmNow = orig }()
+	fn()
+}
+
+func TestSelectEpochAcrossRollovers(t *testing.T) {
+	era2 := DefaultIridiumEpochs[1]
+
+	tests := []struct {
+		name string
+		want time.Time
+	}{
+		{
+			name: "within era2's first window",
+			want: era2.Epoch.Add(365 * 24 * time.Hour),
+		},
+		{
+			name: "5 days after era2's first internal rollover",
+			want: era2.Epoch.Add(msstmRolloverPeriod + 5*24*time.Hour),
+		},
+		{
+			name: "well into era2's second internal rollover",
+			want: era2.Epoch.Add(2*msstmRolloverPeriod + 40*24*time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RockBLOCKSerialConnection{}
+
+			elapsed := tt.want.Sub(era2.Epoch)
+			ticks := uint32(elapsed % msstmRolloverPeriod / (90 * time.Millisecond))
+
+			withMSSTMNow(t, tt.want, func() {
+				got, epoch, err := r.selectEpoch(ticks)
+				if err != nil {
+					t.Fatalf("selectEpoch(): %s", err)
+				}
+				if epoch.Name != "era2" {
+					t.Errorf("selectEpoch() epoch = %q, want era2", epoch.Name)
+				}
+				if diff := got.Sub(tt.want); diff < -time.Second || diff > time.Second {
+					t.Errorf("selectEpoch() = %s, want %s", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestSelectEpochOutOfToleranceIsSuspect(t *testing.T) {
+	r := &RockBLOCKSerialConnection{MSSTMTolerance: time.Hour}
+
+	// A tick count that doesn't land near "now" under any epoch or rollover
+	// multiple should be reported as suspect, not as a bogus timestamp with
+	// a nil error.
+	withMSSTMNow(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), func() {
+		_, _, err := r.selectEpoch(0)
+		if !errors.Is(err, ErrMSSTMSuspect) {
+			t.Fatalf("selectEpoch() error = %v, want ErrMSSTMSuspect", err)
+		}
+		if !r.msstmSuspect {
+			t.Error("selectEpoch() left msstmSuspect false after an out-of-tolerance reading")
+		}
+	})
+}
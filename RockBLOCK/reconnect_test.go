@@ -0,0 +1,61 @@
+package RockBLOCK
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/doyke/weatherserver/RockBLOCK/mock"
+)
+
+// TestReconnectAfterTransportFailure kills the transport mid-session, as if
+// a USB adapter were unplugged, and checks that serialReader's supervisor
+// loop notices, calls Reopen, re-runs the init handshake, and lets a
+// subsequent send go through on the new transport - the scenario request #2
+// (context-aware WaitForNetwork/reinit) exists to make safe.
+func TestReconnectAfterTransportFailure(t *testing.T) {
+	clientSide1, modemSide1 := net.Pipe()
+	m1 := mock.NewModem(modemSide1)
+	t.Cleanup(func() {
+		m1.Close()
+		clientSide1.Close()
+	})
+
+	r, err := NewRockBLOCKSerialWithTransport(DefaultSerialConfig(), clientSide1)
+	if err != nil {
+		t.Fatalf("NewRockBLOCKSerialWithTransport(): %s", err)
+	}
+
+	reopened := make(chan *mock.Modem, 1)
+	r.Reopen = func() (Transport, error) {
+		clientSide2, modemSide2 := net.Pipe()
+		m2 := mock.NewModem(modemSide2)
+		t.Cleanup(func() {
+			m2.Close()
+			clientSide2.Close()
+		})
+		reopened <- m2
+		return clientSide2, nil
+	}
+
+	// Kill the first transport mid-session.
+	m1.Close()
+
+	var m2 *mock.Modem
+	select {
+	case m2 = <-reopened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Reopen() to be called after the transport failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.SendText(ctx, []byte("hello again")); err != nil {
+		t.Fatalf("SendText() after reconnect: %s", err)
+	}
+	if got := string(m2.LastMO()); got != "hello again" {
+		t.Errorf("reconnected modem got MO %q, want %q", got, "hello again")
+	}
+}
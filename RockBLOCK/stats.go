@@ -0,0 +1,52 @@
+package RockBLOCK
+
+import (
+	"time"
+)
+
+// Stats summarizes persistentMessageSender's link and session health so
+// operators can drive a Prometheus exporter off it via r.Stats().
+type Stats struct {
+	Attempts       int
+	BytesSent      int
+	LastSBDIX      SBDISerialResponse
+	LastCSQ        int
+	LastMSSTMDrift time.Duration // Modem-reported SystemTime minus time.Now() as of the last AT-MSSTM.
+}
+
+// Stats returns a snapshot of the connection's send/session counters.
+func (r *RockBLOCKSerialConnection) Stats() Stats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	return r.stats
+}
+
+func (r *RockBLOCKSerialConnection) recordAttempt() {
+	r.statsMu.Lock()
+	r.stats.Attempts++
+	r.statsMu.Unlock()
+}
+
+func (r *RockBLOCKSerialConnection) recordBytesSent(n int) {
+	r.statsMu.Lock()
+	r.stats.BytesSent += n
+	r.statsMu.Unlock()
+}
+
+func (r *RockBLOCKSerialConnection) recordSBDI(s SBDISerialResponse) {
+	r.statsMu.Lock()
+	r.stats.LastSBDIX = s
+	r.statsMu.Unlock()
+}
+
+func (r *RockBLOCKSerialConnection) recordCSQ(q int) {
+	r.statsMu.Lock()
+	r.stats.LastCSQ = q
+	r.statsMu.Unlock()
+}
+
+func (r *RockBLOCKSerialConnection) recordMSSTMDrift(d time.Duration) {
+	r.statsMu.Lock()
+	r.stats.LastMSSTMDrift = d
+	r.statsMu.Unlock()
+}